@@ -1,34 +1,116 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"math/rand"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/getlantern/systray"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/joho/godotenv"
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	sqldblogger "github.com/simukti/sqldb-logger"
 	"github.com/spf13/viper"
 
-	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	hook "github.com/robotn/gohook"
 	"github.com/seatedro/kawaiilogger/db"
-	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/libsql"
 )
 
 type DBConfig struct {
 	Type     string
 	URL      string
 	FilePath string
+	LogLevel string
+	LogArgs  bool
+}
+
+// sqlArgRedactPattern matches args that look like connection URLs so
+// credentials embedded in a DSN never end up in the query log.
+var sqlArgRedactPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// wmClassQuotedFieldPattern matches the quoted fields in xprop's
+// `WM_CLASS(STRING) = "instance", "class"` output.
+var wmClassQuotedFieldPattern = regexp.MustCompile(`"[^"]*"`)
+
+// sqlLoggerAdapter bridges sqldb-logger's structured log callbacks to the
+// package-level `logger`, so query traces land in kawaiilogger.log next to
+// everything else instead of a separate sink.
+type sqlLoggerAdapter struct {
+	level   sqldblogger.Level
+	logArgs bool
+}
+
+func (a *sqlLoggerAdapter) Log(_ context.Context, level sqldblogger.Level, msg string, data map[string]interface{}) {
+	if level < a.level {
+		return
+	}
+
+	if args, ok := data["args"].([]interface{}); ok {
+		if !a.logArgs {
+			delete(data, "args")
+		} else {
+			redacted := make([]interface{}, len(args))
+			for i, arg := range args {
+				if s, ok := arg.(string); ok && sqlArgRedactPattern.MatchString(s) {
+					redacted[i] = "[REDACTED]"
+				} else {
+					redacted[i] = arg
+				}
+			}
+			data["args"] = redacted
+		}
+	}
+
+	logger.Printf("[sql] level=%s msg=%s data=%v", level, msg, data)
+}
+
+// parseSQLLogLevel maps the `database.log_level` config string to a
+// sqldb-logger level, defaulting to only logging errors so a normal run
+// stays quiet.
+func parseSQLLogLevel(level string) sqldblogger.Level {
+	switch level {
+	case "debug":
+		return sqldblogger.LevelDebug
+	case "info":
+		return sqldblogger.LevelInfo
+	case "error", "":
+		return sqldblogger.LevelError
+	default:
+		return sqldblogger.LevelError
+	}
+}
+
+// openLoggedDB wraps drv with sqldb-logger so every Exec/Query/Prepare it
+// serves emits a structured record (query text, args, duration, rows
+// affected, error) through the package logger, regardless of which of the
+// three backends drv actually is.
+func openLoggedDB(drv driver.Driver, dsn string, config DBConfig) *sql.DB {
+	return sqldblogger.OpenDriver(
+		dsn,
+		drv,
+		&sqlLoggerAdapter{level: parseSQLLogLevel(config.LogLevel), logArgs: config.LogArgs},
+		sqldblogger.WithSQLQueryAsMessage(true),
+	)
 }
 
 type Metrics struct {
@@ -39,6 +121,55 @@ type Metrics struct {
 	ScrollSteps     int
 }
 
+// ReportConfig is the opt-in remote reporting peer to DBConfig, modeled on
+// Cwtch's ServerReporting pattern: a group of installs all POST their
+// metrics at one aggregation endpoint instead of sharing a database.
+type ReportConfig struct {
+	Enabled   bool
+	Endpoint  string
+	GroupID   string
+	Interval  time.Duration
+	AuthToken string
+}
+
+// MetricsBatch is the wire format POSTed to the reporting endpoint once per
+// reporting.interval tick (see runReportTicker).
+type MetricsBatch struct {
+	GroupID         string  `json:"group_id"`
+	Host            string  `json:"host"`
+	Ts              int64   `json:"ts"`
+	Keypresses      int     `json:"keypresses"`
+	MouseClicks     int     `json:"mouse_clicks"`
+	MouseDistanceIn float64 `json:"mouse_distance_in"`
+	ScrollSteps     int     `json:"scroll_steps"`
+}
+
+// MetricsConfig controls idle/session segmentation of collected metrics.
+type MetricsConfig struct {
+	IdleThreshold time.Duration
+}
+
+// AppKeypresses is one row of the "top apps today" ranking shown in the
+// systray tooltip.
+type AppKeypresses struct {
+	AppName    string
+	Keypresses int
+}
+
+// OutboxMetricsPayload is what saveMetrics() spools into the `outbox` table
+// for a remote backend, and what drainOutbox() replays from it.
+type OutboxMetricsPayload struct {
+	Keypresses      int32   `json:"keypresses"`
+	MouseClicks     int32   `json:"mouse_clicks"`
+	MouseDistanceIn float64 `json:"mouse_distance_in"`
+	MouseDistanceMi float64 `json:"mouse_distance_mi"`
+	ScrollSteps     int32   `json:"scroll_steps"`
+	SessionID       int64   `json:"session_id"`
+	ActiveSeconds   int32   `json:"active_seconds"`
+	AppName         string  `json:"app_name"`
+	WindowTitle     string  `json:"window_title"`
+}
+
 type TotalMetrics struct {
 	TotalKeypresses      int
 	TotalMouseClicks     int
@@ -47,6 +178,15 @@ type TotalMetrics struct {
 	TotalScrollSteps     int
 }
 
+// appBucket accumulates one app's share of the current session's metrics,
+// so saveMetrics() can emit one row per (session, app) pair instead of
+// crediting a whole minute to whichever app happened to be focused at
+// flush time.
+type appBucket struct {
+	Metrics
+	WindowTitle string
+}
+
 type Monitor struct {
 	XPos     int
 	YPos     int
@@ -57,18 +197,75 @@ type Monitor struct {
 	Ppi      int
 }
 
+const reportQueueCapacity = 256
+
 var (
 	dbQueries              *db.Queries
 	_sqliteDb              *sql.DB
-	metrics                *Metrics
 	totalMetrics           *TotalMetrics
 	logger                 *log.Logger
 	logDir                 string
 	lastMouseX, lastMouseY int
 	monitors               []Monitor
 	monitorsMutex          sync.RWMutex
+
+	reportConfig     ReportConfig
+	reportQueue      chan MetricsBatch
+	reportHTTPClient = &http.Client{Timeout: 10 * time.Second}
+	reportSpoolMutex sync.Mutex
+
+	idleThreshold    time.Duration
+	sessionMutex     sync.Mutex
+	lastEventTime    time.Time
+	sessionStartTime time.Time
+	currentSessionID int64
+
+	outboxMutex      sync.Mutex
+	lastOutboxSyncAt time.Time
+
+	appBucketsMutex    sync.Mutex
+	appBuckets         = make(map[string]*appBucket)
+	currentAppName     string
+	currentWindowTitle string
 )
 
+// activeWindowSampleInterval bounds how often we shell out to the OS helper
+// for the focused app; sampling on every keystroke would be needlessly
+// expensive.
+const activeWindowSampleInterval = 5 * time.Second
+
+// LogConfig controls retention and rotation of kawaiilogger.log, since a
+// machine logging every keypress and mouse event grows the file unbounded
+// otherwise.
+type LogConfig struct {
+	MaxAge       time.Duration
+	RotationTime time.Duration
+	MaxSizeMB    int64
+	Compress     bool
+}
+
+// loadLogConfig reads the `log.*` viper keys, defaulting to a week of daily
+// logs so users who never touch config.yaml still get bounded disk usage.
+func loadLogConfig() LogConfig {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("$HOME/.config/kawaiilogger")
+	_ = viper.ReadInConfig() // Ignore error; defaults below apply either way.
+
+	viper.SetDefault("log.max_age", "168h")
+	viper.SetDefault("log.rotation_time", "24h")
+	viper.SetDefault("log.max_size_mb", 0)
+	viper.SetDefault("log.compress", true)
+
+	return LogConfig{
+		MaxAge:       viper.GetDuration("log.max_age"),
+		RotationTime: viper.GetDuration("log.rotation_time"),
+		MaxSizeMB:    viper.GetInt64("log.max_size_mb"),
+		Compress:     viper.GetBool("log.compress"),
+	}
+}
+
 func initLogger() {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -93,16 +290,72 @@ func initLogger() {
 		log.Fatal("Error creating log directory:", err)
 	}
 
-	logFile := filepath.Join(logDir, "kawaiilogger.log")
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	logConfig := loadLogConfig()
+	rotateOpts := []rotatelogs.Option{
+		rotatelogs.WithMaxAge(logConfig.MaxAge),
+		rotatelogs.WithRotationTime(logConfig.RotationTime),
+	}
+	if runtime.GOOS != "windows" {
+		rotateOpts = append(rotateOpts, rotatelogs.WithLinkName(filepath.Join(logDir, "kawaiilogger.log")))
+	}
+	if logConfig.MaxSizeMB > 0 {
+		rotateOpts = append(rotateOpts, rotatelogs.WithRotationSize(logConfig.MaxSizeMB*1024*1024))
+	}
+	if logConfig.Compress {
+		rotateOpts = append(rotateOpts, rotatelogs.WithHandler(rotatelogs.HandlerFunc(gzipRotatedLog)))
+	}
+
+	writer, err := rotatelogs.New(filepath.Join(logDir, "kawaiilogger.log.%Y%m%d"), rotateOpts...)
 	if err != nil {
-		log.Fatal("Error opening log file:", err)
+		log.Fatal("Error setting up log rotation:", err)
 	}
 
-	logger = log.New(file, "", log.Ldate|log.Ltime|log.Lshortfile)
+	logger = log.New(writer, "", log.Ldate|log.Ltime|log.Lshortfile)
 	logger.Println("kawaiilogger started")
 }
 
+// gzipRotatedLog compresses the previous day's log as soon as
+// file-rotatelogs rolls over to a new one, so N days of retained history
+// cost a fraction of the disk.
+func gzipRotatedLog(e rotatelogs.Event) {
+	rotatedEvent, ok := e.(*rotatelogs.FileRotatedEvent)
+	if !ok {
+		return
+	}
+	prev := rotatedEvent.PreviousFile()
+	if prev == "" {
+		return
+	}
+	if err := gzipFile(prev); err != nil {
+		logger.Printf("failed to compress rotated log %s: %v", prev, err)
+	}
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	gw := gzip.NewWriter(dst)
+	if _, err := io.Copy(gw, src); err != nil {
+		gw.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
 func glfwInit() {
 	if err := glfw.Init(); err != nil {
 		panic(err)
@@ -132,19 +385,35 @@ func initializeDB() {
 	switch config.Type {
 	case "postgres":
 		logger.Println("Connecting to postgres instance...")
-		sqlDb, err = sql.Open("postgres", config.URL)
+		sqlDb = openLoggedDB(&pq.Driver{}, config.URL, config)
+		if err := migratePostgresMetricsTable(sqlDb); err != nil {
+			logger.Fatalf("failed to migrate remote metrics table: %v", err)
+			return
+		}
 		dbQueries = db.New(sqlDb)
 	case "sqlite":
 		logger.Println("Connecting to sqlite instance...")
+		path := config.FilePath
 		if config.URL != "" {
-			sqlDb, err = sql.Open("sqlite3", config.URL)
-		} else {
-			sqlDb, err = sql.Open("sqlite3", config.FilePath)
+			path = config.URL
+		}
+		// Same _busy_timeout/WAL settings as setupDefaultSQLite: this file
+		// replaces the default as _sqliteDb, so metrics inserts and the
+		// systray's tooltip/queue-depth reads hit it concurrently.
+		dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", path)
+		sqlDb = openLoggedDB(&sqlite3.SQLiteDriver{}, dsn, config)
+		if err := setupSQLiteSchema(sqlDb); err != nil {
+			logger.Fatalf("failed to set up configured sqlite db: %v", err)
+			return
 		}
 		_sqliteDb = sqlDb
 	case "libsql":
 		logger.Println("Connecting to libsql instance...")
-		sqlDb, err = sql.Open("libsql", config.URL)
+		sqlDb = openLoggedDB(&libsql.Driver{}, config.URL, config)
+		if err := migrateMetricsTable(sqlDb); err != nil {
+			logger.Fatalf("failed to migrate remote metrics table: %v", err)
+			return
+		}
 		dbQueries = db.New(sqlDb)
 	case "":
 		logger.Println("Setting up default sqlite db...")
@@ -164,6 +433,10 @@ func initializeDB() {
 		logger.Fatalf("failed to ping database: %e", err)
 	}
 
+	if dbQueries != nil {
+		go drainOutbox()
+	}
+
 }
 
 func loadConfig() (DBConfig, error) {
@@ -186,10 +459,15 @@ func loadConfig() (DBConfig, error) {
 	// Load .env if it exists
 	_ = godotenv.Load() // Ignores error if .env doesn't exist
 
+	viper.SetDefault("database.log_level", "error")
+	viper.SetDefault("database.log_args", false)
+
 	config := DBConfig{
 		Type:     viper.GetString("database.type"),
 		URL:      viper.GetString("database.url"),
 		FilePath: viper.GetString("database.filepath"),
+		LogLevel: viper.GetString("database.log_level"),
+		LogArgs:  viper.GetBool("database.log_args"),
 	}
 
 	// Overriding with env vars if set
@@ -202,17 +480,289 @@ func loadConfig() (DBConfig, error) {
 	if dbFilePath := os.Getenv("KL_DB_FILEPATH"); dbFilePath != "" {
 		config.FilePath = dbFilePath
 	}
+	if dbLogLevel := os.Getenv("KL_DB_LOG_LEVEL"); dbLogLevel != "" {
+		config.LogLevel = dbLogLevel
+	}
+	if dbLogArgs := os.Getenv("KL_DB_LOG_ARGS"); dbLogArgs != "" {
+		config.LogArgs = dbLogArgs == "true"
+	}
 
 	return config, nil
 
 }
 
+// loadReportConfig reads the `reporting.*` viper keys. Reporting defaults to
+// disabled so installs that don't opt in never dial out.
+func loadReportConfig() ReportConfig {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("$HOME/.config/kawaiilogger")
+	_ = viper.ReadInConfig()
+
+	viper.SetDefault("reporting.enabled", false)
+	viper.SetDefault("reporting.interval", "60s")
+
+	return ReportConfig{
+		Enabled:   viper.GetBool("reporting.enabled"),
+		Endpoint:  viper.GetString("reporting.endpoint"),
+		GroupID:   viper.GetString("reporting.group_id"),
+		Interval:  viper.GetDuration("reporting.interval"),
+		AuthToken: viper.GetString("reporting.auth_token"),
+	}
+}
+
+// reportAccumulator buffers metrics between report ticks, so reporting runs
+// on its own `reporting.interval` cadence instead of riding along with
+// saveMetrics()'s once-a-minute (or idle-triggered) local flush.
+type reportAccumulator struct {
+	mu              sync.Mutex
+	keypresses      int
+	mouseClicks     int
+	mouseDistanceIn float64
+	scrollSteps     int
+}
+
+func (r *reportAccumulator) add(keypresses, mouseClicks int, mouseDistanceIn float64, scrollSteps int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keypresses += keypresses
+	r.mouseClicks += mouseClicks
+	r.mouseDistanceIn += mouseDistanceIn
+	r.scrollSteps += scrollSteps
+}
+
+func (r *reportAccumulator) drain() (keypresses, mouseClicks int, mouseDistanceIn float64, scrollSteps int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keypresses, mouseClicks, mouseDistanceIn, scrollSteps = r.keypresses, r.mouseClicks, r.mouseDistanceIn, r.scrollSteps
+	r.keypresses, r.mouseClicks, r.mouseDistanceIn, r.scrollSteps = 0, 0, 0, 0
+	return
+}
+
+var pendingReport reportAccumulator
+
+// initReporting sets up the bounded in-memory queue and background drainer
+// for the opt-in reporting subsystem, and replays anything left over from a
+// previous run that couldn't reach the endpoint.
+func initReporting() {
+	reportConfig = loadReportConfig()
+	if !reportConfig.Enabled {
+		return
+	}
+
+	reportQueue = make(chan MetricsBatch, reportQueueCapacity)
+	go replaySpooledReports()
+	go drainReportQueue()
+	go runReportTicker()
+}
+
+// runReportTicker fires on reportConfig.Interval and flushes whatever
+// accumulated in pendingReport since the last tick as a single batch.
+func runReportTicker() {
+	interval := reportConfig.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		keypresses, mouseClicks, mouseDistanceIn, scrollSteps := pendingReport.drain()
+		if keypresses == 0 && mouseClicks == 0 && mouseDistanceIn == 0 && scrollSteps == 0 {
+			continue
+		}
+
+		host, err := os.Hostname()
+		if err != nil {
+			logger.Printf("failed to get hostname for report batch: %v", err)
+		}
+		enqueueReport(MetricsBatch{
+			GroupID:         reportConfig.GroupID,
+			Host:            host,
+			Ts:              time.Now().Unix(),
+			Keypresses:      keypresses,
+			MouseClicks:     mouseClicks,
+			MouseDistanceIn: mouseDistanceIn,
+			ScrollSteps:     scrollSteps,
+		})
+	}
+}
+
+func reportSpoolPath() string {
+	return filepath.Join(logDir, "report-spool.jsonl")
+}
+
+// enqueueReport hands a batch to the drainer, or spools it straight to disk
+// if the in-memory queue is already full.
+func enqueueReport(batch MetricsBatch) {
+	if reportQueue == nil {
+		return
+	}
+	select {
+	case reportQueue <- batch:
+	default:
+		logger.Println("report queue full, spooling batch to disk")
+		spoolReportBatch(batch)
+	}
+}
+
+func drainReportQueue() {
+	for batch := range reportQueue {
+		if err := sendReportWithBackoff(batch); err != nil {
+			logger.Printf("failed to send metrics report, spooling for later: %v", err)
+			spoolReportBatch(batch)
+		}
+	}
+}
+
+// sendReportWithBackoff retries a single batch a handful of times, doubling
+// the delay each time, before giving up and letting the caller spool it.
+func sendReportWithBackoff(batch MetricsBatch) error {
+	const maxAttempts = 5
+	backoff := time.Second
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if lastErr = sendReport(batch); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func sendReport(batch MetricsBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report batch: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, reportConfig.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build report request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if reportConfig.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+reportConfig.AuthToken)
+	}
+
+	resp, err := reportHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send report: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("report endpoint returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// spoolReportBatch appends a batch to the spool file under logDir so it
+// survives a restart and can be replayed once the endpoint is reachable
+// again. Guarded by reportSpoolMutex so an append can't land between
+// replaySpooledReports' read and rewrite of the same file and get lost.
+func spoolReportBatch(batch MetricsBatch) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logger.Printf("failed to marshal spooled report batch: %v", err)
+		return
+	}
+
+	reportSpoolMutex.Lock()
+	defer reportSpoolMutex.Unlock()
+
+	f, err := os.OpenFile(reportSpoolPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Printf("failed to open report spool file: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(body, '\n')); err != nil {
+		logger.Printf("failed to write spooled report batch: %v", err)
+	}
+}
+
+// replaySpooledReports resends anything left in the spool file from a
+// previous run, rewriting the file to keep only batches that still fail.
+// Holds reportSpoolMutex for the whole read-send-rewrite cycle so a batch
+// spooled mid-replay by spoolReportBatch can't be wiped out by the rewrite.
+func replaySpooledReports() {
+	path := reportSpoolPath()
+
+	reportSpoolMutex.Lock()
+	defer reportSpoolMutex.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Printf("failed to read report spool file: %v", err)
+		}
+		return
+	}
+
+	var remaining [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var batch MetricsBatch
+		if err := json.Unmarshal(line, &batch); err != nil {
+			logger.Printf("dropping malformed spooled report batch: %v", err)
+			continue
+		}
+		if err := sendReportWithBackoff(batch); err != nil {
+			remaining = append(remaining, line)
+		}
+	}
+
+	if len(remaining) == 0 {
+		os.Remove(path)
+		return
+	}
+
+	// Keep the trailing newline so a later spoolReportBatch append starts on
+	// its own line instead of merging into the last remaining batch.
+	if err := os.WriteFile(path, append(bytes.Join(remaining, []byte("\n")), '\n'), 0644); err != nil {
+		logger.Printf("failed to rewrite report spool file: %v", err)
+	}
+}
+
+// loadMetricsConfig reads the `metrics.*` viper keys. A 120s idle threshold
+// is long enough to survive a moment's pause without fragmenting a real
+// session, short enough to actually separate "away from keyboard" from work.
+func loadMetricsConfig() MetricsConfig {
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("$HOME/.config/kawaiilogger")
+	_ = viper.ReadInConfig()
+
+	viper.SetDefault("metrics.idle_threshold", "120s")
+
+	return MetricsConfig{
+		IdleThreshold: viper.GetDuration("metrics.idle_threshold"),
+	}
+}
+
 func main() {
 	initLogger()
 	glfwInit()
 	initializeDB()
+	initReporting()
+
+	metricsConfig := loadMetricsConfig()
+	idleThreshold = metricsConfig.IdleThreshold
+	currentSessionID = 1
+	sessionStartTime = time.Now()
 
-	metrics = &Metrics{}
 	totalMetrics = &TotalMetrics{}
 
 	go collectMetrics()
@@ -228,6 +778,8 @@ func onReady() {
 	mMouseClicks := systray.AddMenuItem("Mouse Clicks: 0", "Number of mouse clicks")
 	mMouseDistance := systray.AddMenuItem("Mouse Travel (in) 0 / (mi) 0", "Distance moved by mouse")
 	mScrollSteps := systray.AddMenuItem("Scroll Steps: 0", "Number of scroll steps")
+	mOutboxQueue := systray.AddMenuItem("Outbox Queue: 0", "Metrics rows waiting to sync to the remote database")
+	mLastSync := systray.AddMenuItem("Last Synced: never", "Last time the outbox synced to the remote database")
 
 	systray.AddSeparator()
 	mOpenLog := systray.AddMenuItem("Open Log File", "Open the log file")
@@ -253,8 +805,82 @@ func onReady() {
 			mMouseClicks.SetTitle(fmt.Sprintf("Mouse Clicks: %d", totalMetrics.TotalMouseClicks))
 			mMouseDistance.SetTitle(fmt.Sprintf("Mouse Travel (in) %.2f / (mi) %.2f", totalMetrics.TotalMouseDistanceIn, totalMetrics.TotalMouseDistanceMi))
 			mScrollSteps.SetTitle(fmt.Sprintf("Scroll Steps: %d", totalMetrics.TotalScrollSteps))
+
+			depth, err := outboxQueueDepth()
+			if err != nil {
+				logger.Printf("failed to read outbox queue depth: %v", err)
+			} else {
+				mOutboxQueue.SetTitle(fmt.Sprintf("Outbox Queue: %d", depth))
+			}
+
+			outboxMutex.Lock()
+			lastSync := lastOutboxSyncAt
+			outboxMutex.Unlock()
+			if lastSync.IsZero() {
+				mLastSync.SetTitle("Last Synced: never")
+			} else {
+				mLastSync.SetTitle(fmt.Sprintf("Last Synced: %s", lastSync.Format("15:04:05")))
+			}
 		}
 	}()
+
+	go func() {
+		for {
+			apps, err := topAppsToday()
+			if err != nil {
+				logger.Printf("failed to load top apps for tooltip: %v", err)
+			} else {
+				systray.SetTooltip(buildTooltip(apps))
+			}
+			time.Sleep(time.Second * 30)
+		}
+	}()
+}
+
+// buildTooltip renders today's top 3 apps by keypresses for the systray
+// tooltip, falling back to the plain app name before any metrics exist.
+func buildTooltip(apps []AppKeypresses) string {
+	if len(apps) == 0 {
+		return "KawaiiLogger"
+	}
+
+	tooltip := "KawaiiLogger - Top apps today:"
+	for i, a := range apps {
+		tooltip += fmt.Sprintf("\n%d. %s (%d keys)", i+1, a.AppName, a.Keypresses)
+	}
+	return tooltip
+}
+
+// topAppsToday ranks today's apps by summed keypresses, used for the
+// systray tooltip.
+func topAppsToday() ([]AppKeypresses, error) {
+	if _sqliteDb == nil {
+		return nil, nil
+	}
+
+	rows, err := _sqliteDb.Query(`
+		SELECT app_name, SUM(keypresses) AS total_keypresses
+		FROM metrics
+		WHERE date(timestamp, 'localtime') = date('now', 'localtime') AND app_name IS NOT NULL AND app_name != ''
+		GROUP BY app_name
+		ORDER BY total_keypresses DESC
+		LIMIT 3
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top apps: %w", err)
+	}
+	defer rows.Close()
+
+	var apps []AppKeypresses
+	for rows.Next() {
+		var a AppKeypresses
+		if err := rows.Scan(&a.AppName, &a.Keypresses); err != nil {
+			return nil, fmt.Errorf("failed to scan top app row: %w", err)
+		}
+		apps = append(apps, a)
+	}
+
+	return apps, rows.Err()
 }
 
 func onExit() {
@@ -262,7 +888,7 @@ func onExit() {
 }
 
 func openLogFile() {
-	logFile := filepath.Join(logDir, "kawaiilogger.log")
+	logFile := currentLogFilePath()
 	var command string
 	switch os := runtime.GOOS; os {
 	case "darwin":
@@ -284,14 +910,96 @@ func openLogFile() {
 	}
 }
 
+// currentLogFilePath returns today's active log file. On darwin/linux this
+// is the `kawaiilogger.log` symlink file-rotatelogs maintains; windows gets
+// no symlink, so it resolves the dated file directly.
+func currentLogFilePath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(logDir, fmt.Sprintf("kawaiilogger.log.%s", time.Now().Format("20060102")))
+	}
+	return filepath.Join(logDir, "kawaiilogger.log")
+}
+
+// markActivity records that an event just fired and, if the gap since the
+// last one is at least idleThreshold, flushes the ending session's metrics
+// and starts a new session. Returns whether this call just ended an idle
+// gap, so callers like the MouseMove handler can discard data that would
+// otherwise look like a huge jump from a stale cursor position.
+func markActivity() bool {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	now := time.Now()
+	wasIdle := !lastEventTime.IsZero() && now.Sub(lastEventTime) >= idleThreshold
+	if wasIdle {
+		// Flush up to lastEventTime, not now: now is already past the idle
+		// gap, so using it would count the idle gap itself as active time.
+		saveMetrics(lastEventTime)
+		currentSessionID++
+		sessionStartTime = now
+	}
+	lastEventTime = now
+
+	return wasIdle
+}
+
+// periodicSaveMetrics is the once-a-minute tick; unlike markActivity's
+// idle-triggered flush, it doesn't start a new session, just a new active
+// window within the current one.
+func periodicSaveMetrics() {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	now := time.Now()
+	saveMetrics(now)
+	sessionStartTime = now
+}
+
+// sampleActiveWindowLoop periodically refreshes currentAppName/
+// currentWindowTitle so event handlers can cheaply attribute to "whichever
+// app was focused most recently" without shelling out on every keystroke.
+func sampleActiveWindowLoop() {
+	for {
+		appName, windowTitle := getActiveWindow()
+
+		appBucketsMutex.Lock()
+		currentAppName = appName
+		currentWindowTitle = windowTitle
+		appBucketsMutex.Unlock()
+
+		time.Sleep(activeWindowSampleInterval)
+	}
+}
+
+// addToAppBucket attributes an event to the app sampled most recently,
+// creating its bucket on first use. This is what lets saveMetrics() emit
+// one row per (session, app) pair instead of crediting everything to the
+// app that happens to be focused at flush time.
+func addToAppBucket(update func(b *appBucket)) {
+	appBucketsMutex.Lock()
+	defer appBucketsMutex.Unlock()
+
+	b, ok := appBuckets[currentAppName]
+	if !ok {
+		b = &appBucket{}
+		appBuckets[currentAppName] = b
+	}
+	b.WindowTitle = currentWindowTitle
+	update(b)
+}
+
 func collectMetrics() {
+	go sampleActiveWindowLoop()
+
 	hook.Register(hook.KeyDown, nil, func(e hook.Event) {
-		metrics.Keypresses++
+		markActivity()
+		addToAppBucket(func(b *appBucket) { b.Keypresses++ })
 		totalMetrics.TotalKeypresses++
 	})
 
 	hook.Register(hook.MouseDown, nil, func(e hook.Event) {
-		metrics.MouseClicks++
+		markActivity()
+		addToAppBucket(func(b *appBucket) { b.MouseClicks++ })
 		totalMetrics.TotalMouseClicks++
 	})
 
@@ -299,24 +1007,34 @@ func collectMetrics() {
 
 	hook.Register(hook.MouseMove, nil, func(e hook.Event) {
 		newX, newY := int(e.X), int(e.Y)
+		if wasIdle := markActivity(); wasIdle {
+			// The cursor position is stale after an idle gap; resync it
+			// without counting the "distance" back to where it last was.
+			lastMouseX, lastMouseY = newX, newY
+			return
+		}
+
 		distance := calculateMultiMonitorDistance(lastMouseX, lastMouseY, newX, newY)
-		metrics.MouseDistanceIn += distance
-		metrics.MouseDistanceMi += (distance / 63360)
+		addToAppBucket(func(b *appBucket) {
+			b.MouseDistanceIn += distance
+			b.MouseDistanceMi += distance / 63360
+		})
 		totalMetrics.TotalMouseDistanceIn += (distance)
 		totalMetrics.TotalMouseDistanceMi += (distance / 63360)
 		lastMouseX, lastMouseY = newX, newY
 	})
 
 	hook.Register(hook.MouseWheel, nil, func(e hook.Event) {
+		markActivity()
 		distance := int(math.Abs(float64(e.Rotation)))
-		metrics.ScrollSteps += distance
+		addToAppBucket(func(b *appBucket) { b.ScrollSteps += distance })
 		totalMetrics.TotalScrollSteps += distance
 	})
 
 	go func() {
 		for {
 			time.Sleep(time.Second * 60)
-			saveMetrics()
+			periodicSaveMetrics()
 		}
 	}()
 
@@ -324,39 +1042,302 @@ func collectMetrics() {
 	<-hook.Process(s)
 }
 
-func saveMetrics() {
-	// We use the sqlite db here
-	_, err := _sqliteDb.Exec(`
-		INSERT INTO metrics (keypresses, mouse_clicks, mouse_distance_in, mouse_distance_mi, scroll_steps)
-		VALUES (?, ?, ?, ?, ?)
-	`, metrics.Keypresses, metrics.MouseClicks, metrics.MouseDistanceIn, metrics.MouseDistanceMi, metrics.ScrollSteps)
+// saveMetrics writes one row per (session, app) pair for whatever
+// accumulated in appBuckets since the last call. endTime marks the end of
+// the active window being flushed — callers pass lastEventTime rather than
+// now when closing a session on an idle gap, so the gap itself isn't
+// counted as active time. Caller must hold sessionMutex, since it reads
+// currentSessionID/sessionStartTime.
+func saveMetrics(endTime time.Time) {
+	activeSeconds := int(endTime.Sub(sessionStartTime).Seconds())
+	sessionID := currentSessionID
+
+	appBucketsMutex.Lock()
+	buckets := appBuckets
+	appBuckets = make(map[string]*appBucket)
+	appBucketsMutex.Unlock()
+
+	for appName, b := range buckets {
+		saveAppBucket(sessionID, activeSeconds, appName, b)
+	}
+}
 
+// saveAppBucket persists one (session, app) row: the local SQLite insert,
+// the outbox companion row for a configured remote backend, and the
+// optional reporting batch. The metrics insert and its outbox row are
+// wrapped in one transaction so a crash between them can never leave a
+// metrics row with no outbox companion to sync later.
+func saveAppBucket(sessionID int64, activeSeconds int, appName string, b *appBucket) {
+	tx, err := _sqliteDb.Begin()
 	if err != nil {
-		logger.Printf("failed to save metrics: %v", err)
+		logger.Printf("failed to begin metrics transaction for app %q: %v", appName, err)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO metrics (keypresses, mouse_clicks, mouse_distance_in, mouse_distance_mi, scroll_steps, session_id, active_seconds, app_name, window_title)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, b.Keypresses, b.MouseClicks, b.MouseDistanceIn, b.MouseDistanceMi, b.ScrollSteps, sessionID, activeSeconds, appName, b.WindowTitle)
+	if err != nil {
+		tx.Rollback()
+		logger.Printf("failed to save metrics for app %q: %v", appName, err)
 		return
 	}
 
 	if dbQueries != nil {
-		_, err := dbQueries.CreateMetrics(context.Background(), db.CreateMetricsParams{
-			Keypresses:      int32(metrics.Keypresses),
-			MouseClicks:     int32(metrics.MouseClicks),
-			MouseDistanceIn: metrics.MouseDistanceIn,
-			MouseDistanceMi: metrics.MouseDistanceMi,
-			ScrollSteps:     int32(metrics.ScrollSteps),
+		if err := enqueueOutbox(tx, OutboxMetricsPayload{
+			Keypresses:      int32(b.Keypresses),
+			MouseClicks:     int32(b.MouseClicks),
+			MouseDistanceIn: b.MouseDistanceIn,
+			MouseDistanceMi: b.MouseDistanceMi,
+			ScrollSteps:     int32(b.ScrollSteps),
+			SessionID:       sessionID,
+			ActiveSeconds:   int32(activeSeconds),
+			AppName:         appName,
+			WindowTitle:     b.WindowTitle,
+		}); err != nil {
+			tx.Rollback()
+			logger.Printf("failed to enqueue outbox row for app %q: %v", appName, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Printf("failed to commit metrics transaction for app %q: %v", appName, err)
+		return
+	}
+
+	if reportConfig.Enabled {
+		pendingReport.add(b.Keypresses, b.MouseClicks, b.MouseDistanceIn, b.ScrollSteps)
+	}
+}
+
+const outboxPollInterval = 15 * time.Second
+const outboxBatchSize = 50
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx, so enqueueOutbox
+// can run standalone or inside a caller's transaction.
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueueOutbox spools a row alongside the local SQLite insert so a remote
+// DB outage doesn't drop the metrics; drainOutbox() replays it later. It
+// takes a sqlExecutor rather than _sqliteDb directly so saveAppBucket can
+// run it in the same transaction as the metrics insert it accompanies.
+func enqueueOutbox(tx sqlExecutor, payload OutboxMetricsPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox payload: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO outbox (payload_json) VALUES (?)
+	`, string(body)); err != nil {
+		return fmt.Errorf("failed to enqueue outbox row: %w", err)
+	}
+	return nil
+}
+
+// drainOutbox polls the outbox table and replays whatever's due into the
+// remote dbQueries, so metrics collected while offline sync once the
+// connection (or the remote DB) comes back.
+func drainOutbox() {
+	for {
+		if err := drainOutboxOnce(); err != nil {
+			logger.Printf("failed to drain outbox: %v", err)
+		}
+		time.Sleep(outboxPollInterval)
+	}
+}
+
+func drainOutboxOnce() error {
+	type outboxRow struct {
+		id       int64
+		payload  string
+		attempts int
+	}
+
+	rows, err := _sqliteDb.Query(`
+		SELECT id, payload_json, attempts
+		FROM outbox
+		WHERE next_retry_at <= CURRENT_TIMESTAMP
+		ORDER BY id
+		LIMIT ?
+	`, outboxBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to query outbox: %w", err)
+	}
+
+	var due []outboxRow
+	for rows.Next() {
+		var r outboxRow
+		if err := rows.Scan(&r.id, &r.payload, &r.attempts); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		due = append(due, r)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	for _, r := range due {
+		var payload OutboxMetricsPayload
+		if err := json.Unmarshal([]byte(r.payload), &payload); err != nil {
+			logger.Printf("dropping malformed outbox row %d: %v", r.id, err)
+			if _, err := _sqliteDb.Exec(`DELETE FROM outbox WHERE id = ?`, r.id); err != nil {
+				logger.Printf("failed to delete malformed outbox row %d: %v", r.id, err)
+			}
+			continue
+		}
+
+		// NOTE: this assumes db.CreateMetricsParams has been regenerated
+		// from schema.sql to carry session_id/active_seconds/app_name/
+		// window_title; the generated db package isn't part of this
+		// checkout, so that regen has to happen wherever it's sourced from.
+		_, sendErr := dbQueries.CreateMetrics(context.Background(), db.CreateMetricsParams{
+			Keypresses:      payload.Keypresses,
+			MouseClicks:     payload.MouseClicks,
+			MouseDistanceIn: payload.MouseDistanceIn,
+			MouseDistanceMi: payload.MouseDistanceMi,
+			ScrollSteps:     payload.ScrollSteps,
+			SessionID:       payload.SessionID,
+			ActiveSeconds:   payload.ActiveSeconds,
+			AppName:         payload.AppName,
+			WindowTitle:     payload.WindowTitle,
 		})
+		if sendErr != nil {
+			attempts := r.attempts + 1
+			delaySeconds := int(outboxBackoff(attempts).Seconds())
+			if _, err := _sqliteDb.Exec(`
+				UPDATE outbox
+				SET attempts = ?, last_error = ?, next_retry_at = datetime('now', ?)
+				WHERE id = ?
+			`, attempts, sendErr.Error(), fmt.Sprintf("+%d seconds", delaySeconds), r.id); err != nil {
+				logger.Printf("failed to update outbox row %d: %v", r.id, err)
+			}
+			continue
+		}
+
+		if _, err := _sqliteDb.Exec(`DELETE FROM outbox WHERE id = ?`, r.id); err != nil {
+			logger.Printf("failed to delete synced outbox row %d: %v", r.id, err)
+			continue
+		}
+
+		outboxMutex.Lock()
+		lastOutboxSyncAt = time.Now()
+		outboxMutex.Unlock()
+	}
+
+	return nil
+}
+
+// outboxBackoff doubles the retry delay per attempt, capped around an hour,
+// with up-to-50% jitter so a reconnect storm doesn't retry every row in
+// lockstep.
+func outboxBackoff(attempts int) time.Duration {
+	exponent := attempts
+	if exponent > 12 {
+		exponent = 12
+	}
+	base := time.Second * time.Duration(int64(1)<<uint(exponent))
+	jitter := time.Duration(rand.Int63n(int64(base)/2 + 1))
+	return base + jitter
+}
+
+// outboxQueueDepth reports how many rows are waiting to sync, shown in the
+// systray menu.
+func outboxQueueDepth() (int, error) {
+	if _sqliteDb == nil {
+		return 0, nil
+	}
+
+	var count int
+	if err := _sqliteDb.QueryRow(`SELECT COUNT(*) FROM outbox`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count outbox rows: %w", err)
+	}
+	return count, nil
+}
+
+// winForegroundProcessScript P/Invokes GetForegroundWindow via PowerShell,
+// since there's no pure-Go way to reach it without cgo.
+const winForegroundProcessScript = `
+$sig = '[DllImport("user32.dll")] public static extern IntPtr GetForegroundWindow(); [DllImport("user32.dll")] public static extern uint GetWindowThreadProcessId(IntPtr hWnd, out uint lpdwProcessId);'
+Add-Type -MemberDefinition $sig -Name Win32 -Namespace KawaiiLogger
+$hwnd = [KawaiiLogger.Win32]::GetForegroundWindow()
+$procId = 0
+$null = [KawaiiLogger.Win32]::GetWindowThreadProcessId($hwnd, [ref]$procId)
+(Get-Process -Id $procId).ProcessName
+`
+
+// getActiveWindow shells out to a small platform helper to resolve the
+// foreground app, since none of xdotool/NSWorkspace/GetForegroundWindow are
+// reachable from pure Go without cgo.
+func getActiveWindow() (appName, windowTitle string) {
+	switch runtime.GOOS {
+	case "linux":
+		title, err := exec.Command("xdotool", "getactivewindow", "getwindowname").Output()
+		if err != nil {
+			logger.Printf("failed to get active window title: %v", err)
+			return "", ""
+		}
+		windowTitle = strings.TrimSpace(string(title))
+
+		return linuxActiveWindowClass(), windowTitle
+	case "darwin":
+		out, err := exec.Command("osascript", "-e",
+			`tell application "System Events" to get name of first application process whose frontmost is true`).Output()
 		if err != nil {
-			logger.Printf("Error saving metrics: %v", err)
+			logger.Printf("failed to get active window: %v", err)
+			return "", ""
 		}
+		appName = strings.TrimSpace(string(out))
+		return appName, appName
+	case "windows":
+		out, err := exec.Command("powershell", "-NoProfile", "-Command", winForegroundProcessScript).Output()
+		if err != nil {
+			logger.Printf("failed to get active window: %v", err)
+			return "", ""
+		}
+		appName = strings.TrimSpace(string(out))
+		return appName, appName
+	default:
+		return "", ""
 	}
-	resetMetrics()
 }
 
-func resetMetrics() {
-	metrics.Keypresses = 0
-	metrics.MouseClicks = 0
-	metrics.MouseDistanceIn = 0
-	metrics.MouseDistanceMi = 0
-	metrics.ScrollSteps = 0
+// linuxActiveWindowClass resolves the focused window's WM_CLASS via xprop;
+// xdotool itself has no subcommand for this (only getwindowname/
+// getwindowpid), so getactivewindow's window id is piped into xprop.
+func linuxActiveWindowClass() string {
+	windowID, err := exec.Command("xdotool", "getactivewindow").Output()
+	if err != nil {
+		logger.Printf("failed to get active window id: %v", err)
+		return ""
+	}
+
+	out, err := exec.Command("xprop", "-id", strings.TrimSpace(string(windowID)), "WM_CLASS").Output()
+	if err != nil {
+		logger.Printf("failed to get active window class: %v", err)
+		return ""
+	}
+
+	return parseWMClass(string(out))
+}
+
+// parseWMClass pulls the class name out of xprop's
+// `WM_CLASS(STRING) = "instance", "class"` output, preferring the class
+// (the last field) over the instance name.
+func parseWMClass(wmClass string) string {
+	fields := wmClassQuotedFieldPattern.FindAllString(wmClass, -1)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.Trim(fields[len(fields)-1], `"`)
 }
 
 func calculateDistance(x1, y1, x2, y2 int) float64 {
@@ -462,13 +1443,35 @@ func setupDefaultSQLite() error {
 	}
 
 	dbPath := filepath.Join(dbDir, "kawaiilogger.db")
-	sqlDb, err := sql.Open("sqlite3", dbPath)
+	// _busy_timeout makes SQLite retry on SQLITE_BUSY instead of failing
+	// immediately, and WAL lets the outbox drainer's reads/writes proceed
+	// concurrently with enqueueOutbox's inserts instead of contending for
+	// a single file lock.
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", dbPath)
+	sqlDb, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to open default SQLite database: %w", err)
 	}
 
+	if err := setupSQLiteSchema(sqlDb); err != nil {
+		return err
+	}
+
+	logger.Println("Created default sqlite db...")
+	_sqliteDb = sqlDb
+
+	return nil
+
+}
+
+// setupSQLiteSchema creates the metrics/outbox tables (if missing) and
+// backfills any columns added since, against whatever sqlite file sqlDb
+// points at. Every sqlite connection _sqliteDb is assigned to, default or
+// user-configured, needs this run once before saveAppBucket/enqueueOutbox
+// can write to it.
+func setupSQLiteSchema(sqlDb *sql.DB) error {
 	// Create tables if they don't exist
-	_, err = sqlDb.Exec(`
+	_, err := sqlDb.Exec(`
 		CREATE TABLE IF NOT EXISTS metrics (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -476,16 +1479,103 @@ func setupDefaultSQLite() error {
 			mouse_clicks INTEGER,
 			mouse_distance_in REAL,
 			mouse_distance_mi REAL,
-			scroll_steps INTEGER
+			scroll_steps INTEGER,
+			session_id INTEGER,
+			active_seconds INTEGER,
+			app_name TEXT,
+			window_title TEXT
 		)
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create default tables: %w", err)
 	}
 
-	logger.Println("Created default sqlite db...")
-	_sqliteDb = sqlDb
+	if err := migrateMetricsTable(sqlDb); err != nil {
+		return fmt.Errorf("failed to migrate metrics table: %w", err)
+	}
+
+	_, err = sqlDb.Exec(`
+		CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			payload_json TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_retry_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
 
 	return nil
+}
 
+// migrateMetricsTable backfills columns added to the metrics table after
+// it first shipped, for databases created before session/app tracking
+// existed. CREATE TABLE IF NOT EXISTS won't add them on its own.
+func migrateMetricsTable(sqlDb *sql.DB) error {
+	rows, err := sqlDb.Query(`PRAGMA table_info(metrics)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dfltValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	newColumns := []struct {
+		name    string
+		colType string
+	}{
+		{"session_id", "INTEGER"},
+		{"active_seconds", "INTEGER"},
+		{"app_name", "TEXT"},
+		{"window_title", "TEXT"},
+	}
+	for _, col := range newColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := sqlDb.Exec(fmt.Sprintf("ALTER TABLE metrics ADD COLUMN %s %s", col.name, col.colType)); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.name, err)
+		}
+	}
+
+	return nil
+}
+
+// migratePostgresMetricsTable is migrateMetricsTable's counterpart for a
+// remote postgres backend, whose metrics table is provisioned outside this
+// program. Unlike sqlite, postgres supports "ADD COLUMN IF NOT EXISTS"
+// directly, so there's no need to inspect existing columns first.
+func migratePostgresMetricsTable(sqlDb *sql.DB) error {
+	newColumns := []struct {
+		name    string
+		colType string
+	}{
+		{"session_id", "BIGINT"},
+		{"active_seconds", "INTEGER"},
+		{"app_name", "TEXT"},
+		{"window_title", "TEXT"},
+	}
+	for _, col := range newColumns {
+		stmt := fmt.Sprintf("ALTER TABLE metrics ADD COLUMN IF NOT EXISTS %s %s", col.name, col.colType)
+		if _, err := sqlDb.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to add column %s: %w", col.name, err)
+		}
+	}
+
+	return nil
 }